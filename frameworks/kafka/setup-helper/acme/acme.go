@@ -0,0 +1,224 @@
+// Package acme provisions and renews a broker's TLS certificate from an
+// ACME directory (e.g. Let's Encrypt) using the tls-alpn-01 challenge, so
+// operators don't have to hand-manage cert/key files or run a sidecar to
+// keep them current.
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"time"
+
+	xacme "golang.org/x/crypto/acme"
+)
+
+const challengeTypeTLSALPN01 = "tls-alpn-01"
+
+// Provisioner requests a certificate for a single domain from an ACME
+// directory. It's reused across renewals: the account it registers on
+// first use is kept for subsequent calls to RequestCertificate.
+type Provisioner struct {
+	DirectoryURL string
+	ContactEmail string
+	Domain       string
+
+	// ChallengeAddr is the "host:port" this provisioner briefly listens on
+	// to answer the CA's tls-alpn-01 validation connection. It must be
+	// reachable from the ACME server at Domain on this address, and must
+	// be a dedicated port distinct from any the broker itself binds --
+	// this same Provisioner is reused across renewals, which run while
+	// the broker is already live and listening on its own ports.
+	ChallengeAddr string
+
+	client     *xacme.Client
+	registered bool
+}
+
+// Certificate is a provisioned certificate/key pair plus the expiry of the
+// leaf, so callers know when to renew.
+type Certificate struct {
+	CertPEM  []byte
+	KeyPEM   []byte
+	NotAfter time.Time
+}
+
+// NewProvisioner returns a Provisioner for domain, requesting certificates
+// from directoryURL and registering contactEmail with the CA. challengeAddr
+// is the address to serve the tls-alpn-01 challenge on.
+func NewProvisioner(directoryURL, contactEmail, domain, challengeAddr string) *Provisioner {
+	return &Provisioner{
+		DirectoryURL:  directoryURL,
+		ContactEmail:  contactEmail,
+		Domain:        domain,
+		ChallengeAddr: challengeAddr,
+	}
+}
+
+// RequestCertificate drives a full ACME order for p.Domain: it registers
+// an account on first use, satisfies the tls-alpn-01 challenge for every
+// pending authorization by briefly listening on p.ChallengeAddr, then
+// finalizes the order and returns the issued certificate.
+func (p *Provisioner) RequestCertificate(ctx context.Context) (*Certificate, error) {
+	if err := p.ensureClient(); err != nil {
+		return nil, err
+	}
+	if !p.registered {
+		if _, err := p.client.Register(ctx, &xacme.Account{Contact: []string{"mailto:" + p.ContactEmail}}, xacme.AcceptTOS); err != nil {
+			return nil, fmt.Errorf("registering ACME account: %s", err)
+		}
+		p.registered = true
+	}
+
+	order, err := p.client.AuthorizeOrder(ctx, xacme.DomainIDs(p.Domain))
+	if err != nil {
+		return nil, fmt.Errorf("creating order for %s: %s", p.Domain, err)
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		if err := p.satisfyAuthorization(ctx, authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.finalize(ctx, order.FinalizeURL)
+}
+
+func (p *Provisioner) ensureClient() error {
+	if p.client != nil {
+		return nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generating ACME account key: %s", err)
+	}
+	p.client = &xacme.Client{Key: key, DirectoryURL: p.DirectoryURL}
+	return nil
+}
+
+// satisfyAuthorization fetches a single authorization and, unless it's
+// already valid, completes its tls-alpn-01 challenge.
+func (p *Provisioner) satisfyAuthorization(ctx context.Context, authzURL string) error {
+	authz, err := p.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("fetching authorization: %s", err)
+	}
+	if authz.Status == xacme.StatusValid {
+		return nil
+	}
+
+	chal := pickChallenge(authz, challengeTypeTLSALPN01)
+	if chal == nil {
+		return fmt.Errorf("CA did not offer a %s challenge for %s", challengeTypeTLSALPN01, p.Domain)
+	}
+
+	cert, err := p.client.TLSALPN01ChallengeCert(chal.Token, p.Domain)
+	if err != nil {
+		return fmt.Errorf("building %s challenge cert: %s", challengeTypeTLSALPN01, err)
+	}
+
+	stop, err := serveChallenge(p.ChallengeAddr, cert)
+	if err != nil {
+		return fmt.Errorf("serving %s challenge: %s", challengeTypeTLSALPN01, err)
+	}
+	defer stop()
+
+	if _, err := p.client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accepting challenge: %s", err)
+	}
+	if _, err := p.client.WaitAuthorization(ctx, authzURL); err != nil {
+		return fmt.Errorf("waiting for authorization: %s", err)
+	}
+	return nil
+}
+
+func (p *Provisioner) finalize(ctx context.Context, finalizeURL string) (*Certificate, error) {
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating certificate key: %s", err)
+	}
+	csr, err := certRequest(certKey, p.Domain)
+	if err != nil {
+		return nil, fmt.Errorf("building CSR: %s", err)
+	}
+
+	der, _, err := p.client.CreateOrderCert(ctx, finalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("finalizing order: %s", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing issued certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var certPEM []byte
+	for _, b := range der {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return &Certificate{CertPEM: certPEM, KeyPEM: keyPEM, NotAfter: leaf.NotAfter}, nil
+}
+
+func pickChallenge(authz *xacme.Authorization, typ string) *xacme.Challenge {
+	for _, c := range authz.Challenges {
+		if c.Type == typ {
+			return c
+		}
+	}
+	return nil
+}
+
+func certRequest(key *ecdsa.PrivateKey, domain string) ([]byte, error) {
+	tmpl := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}
+	return x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+}
+
+// serveChallenge listens on addr and answers tls-alpn-01 validation
+// connections with cert until the returned stop func is called. This lets
+// the setup process answer the challenge itself during startup/renewal
+// instead of requiring a dedicated sidecar. It binds addr on its own, so
+// it cannot share a port the broker has already bound.
+func serveChallenge(addr string, cert tls.Certificate) (stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsLn := tls.NewListener(ln, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"acme-tls/1"},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := tlsLn.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	return func() {
+		tlsLn.Close()
+		<-done
+	}, nil
+}
@@ -0,0 +1,300 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jwsEnvelope is the outer JWS wrapper every signed ACME request arrives in.
+// The mock CA below only needs the payload, not signature verification.
+type jwsEnvelope struct {
+	Payload string `json:"payload"`
+}
+
+func decodeJWSPayload(r *http.Request, v interface{}) error {
+	var env jwsEnvelope
+	if err := json.NewDecoder(r.Body).Decode(&env); err != nil {
+		return err
+	}
+	if env.Payload == "" {
+		// POST-as-GET carries an empty payload; nothing to decode.
+		return nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// mockCA is a bare-bones stand-in for an RFC 8555 ACME server: just enough
+// wire protocol to drive Provisioner.RequestCertificate end to end. It
+// trusts every signed request at face value (no JWS verification) and
+// treats every tls-alpn-01 challenge as satisfied the moment it's accepted,
+// since exercising the real validation dial-back isn't this test's job.
+type mockCA struct {
+	mu     sync.Mutex
+	key    *ecdsa.PrivateKey
+	cert   *x509.Certificate
+	nextID int
+	authzs map[string]*mockAuthz
+	certs  map[string][]byte
+
+	offerChallenge bool // when false, authorizations list no challenges
+}
+
+type mockAuthz struct {
+	status string
+	domain string
+}
+
+func newMockCA(t *testing.T, offerChallenge bool) *httptest.Server {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mock ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &mockCA{
+		key:            caKey,
+		cert:           caCert,
+		authzs:         make(map[string]*mockAuthz),
+		certs:          make(map[string][]byte),
+		offerChallenge: offerChallenge,
+	}
+
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/directory", func(w http.ResponseWriter, r *http.Request) {
+		ca.setNonce(w)
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   srv.URL + "/new-nonce",
+			"newAccount": srv.URL + "/new-account",
+			"newOrder":   srv.URL + "/new-order",
+		})
+	})
+
+	mux.HandleFunc("/new-nonce", func(w http.ResponseWriter, r *http.Request) {
+		ca.setNonce(w)
+	})
+
+	mux.HandleFunc("/new-account", func(w http.ResponseWriter, r *http.Request) {
+		ca.setNonce(w)
+		w.Header().Set("Location", srv.URL+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "valid"})
+	})
+
+	mux.HandleFunc("/new-order", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Identifiers []struct {
+				Value string `json:"value"`
+			} `json:"identifiers"`
+		}
+		if err := decodeJWSPayload(r, &req); err != nil || len(req.Identifiers) == 0 {
+			ca.setNonce(w)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ca.mu.Lock()
+		ca.nextID++
+		id := fmt.Sprintf("%d", ca.nextID)
+		ca.authzs[id] = &mockAuthz{status: "pending", domain: req.Identifiers[0].Value}
+		ca.mu.Unlock()
+
+		ca.setNonce(w)
+		w.Header().Set("Location", fmt.Sprintf("%s/order/%s", srv.URL, id))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":         "pending",
+			"authorizations": []string{fmt.Sprintf("%s/authz/%s", srv.URL, id)},
+			"finalize":       fmt.Sprintf("%s/finalize/%s", srv.URL, id),
+		})
+	})
+
+	mux.HandleFunc("/authz/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/authz/")
+		ca.mu.Lock()
+		authz := ca.authzs[id]
+		ca.mu.Unlock()
+		if authz == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		challenges := []map[string]string{}
+		if ca.offerChallenge {
+			challenges = append(challenges, map[string]string{
+				"type":   challengeTypeTLSALPN01,
+				"url":    fmt.Sprintf("%s/chal/%s", srv.URL, id),
+				"token":  "token-" + id,
+				"status": authz.status,
+			})
+		}
+
+		ca.setNonce(w)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":     authz.status,
+			"identifier": map[string]string{"type": "dns", "value": authz.domain},
+			"challenges": challenges,
+		})
+	})
+
+	mux.HandleFunc("/chal/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/chal/")
+		ca.mu.Lock()
+		if authz, ok := ca.authzs[id]; ok {
+			authz.status = "valid"
+		}
+		ca.mu.Unlock()
+
+		ca.setNonce(w)
+		json.NewEncoder(w).Encode(map[string]string{
+			"type":   challengeTypeTLSALPN01,
+			"url":    fmt.Sprintf("%s/chal/%s", srv.URL, id),
+			"token":  "token-" + id,
+			"status": "valid",
+		})
+	})
+
+	mux.HandleFunc("/finalize/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/finalize/")
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		if err := decodeJWSPayload(r, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		csrDER, err := base64.RawURLEncoding.DecodeString(req.CSR)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		csr, err := x509.ParseCertificateRequest(csrDER)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		leafTmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(1000 + ca.nextID)),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}
+		leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca.cert, csr.PublicKey, ca.key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+
+		ca.mu.Lock()
+		ca.certs[id] = certPEM
+		ca.mu.Unlock()
+
+		ca.setNonce(w)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":      "valid",
+			"certificate": fmt.Sprintf("%s/cert/%s", srv.URL, id),
+		})
+	})
+
+	mux.HandleFunc("/cert/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/cert/")
+		ca.mu.Lock()
+		cert := ca.certs[id]
+		ca.mu.Unlock()
+		if cert == nil {
+			http.NotFound(w, r)
+			return
+		}
+		ca.setNonce(w)
+		w.Write(cert)
+	})
+
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (ca *mockCA) setNonce(w http.ResponseWriter) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	w.Header().Set("Replay-Nonce", base64.RawURLEncoding.EncodeToString(b))
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestRequestCertificate(t *testing.T) {
+	srv := newMockCA(t, true)
+
+	p := NewProvisioner(srv.URL+"/directory", "ops@example.com", "broker-0.example.com", fmt.Sprintf(":%d", freePort(t)))
+	cert, err := p.RequestCertificate(context.Background())
+	require.NoError(t, err)
+
+	block, _ := pem.Decode(cert.CertPEM)
+	require.NotNil(t, block)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"broker-0.example.com"}, leaf.DNSNames)
+	assert.True(t, cert.NotAfter.After(time.Now()))
+	assert.NotEmpty(t, cert.KeyPEM)
+}
+
+func TestRequestCertificateNoChallengeOffered(t *testing.T) {
+	srv := newMockCA(t, false)
+
+	p := NewProvisioner(srv.URL+"/directory", "ops@example.com", "broker-0.example.com", fmt.Sprintf(":%d", freePort(t)))
+	_, err := p.RequestCertificate(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "did not offer a "+challengeTypeTLSALPN01+" challenge")
+}
+
+func TestRequestCertificateUnreachableDirectory(t *testing.T) {
+	p := NewProvisioner("http://127.0.0.1:0/directory", "ops@example.com", "broker-0.example.com", fmt.Sprintf(":%d", freePort(t)))
+	_, err := p.RequestCertificate(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package acme
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Renewer keeps a certificate on disk current by re-running a Provisioner
+// shortly before the existing certificate expires, so a broker can keep
+// renewing without a separate sidecar process.
+type Renewer struct {
+	Provisioner *Provisioner
+	CertPath    string
+	KeyPath     string
+	RenewBefore time.Duration
+}
+
+// NewRenewer returns a Renewer that writes renewed certificates to certPath
+// and keyPath, starting renewBefore ahead of expiry.
+func NewRenewer(p *Provisioner, certPath, keyPath string, renewBefore time.Duration) *Renewer {
+	return &Renewer{Provisioner: p, CertPath: certPath, KeyPath: keyPath, RenewBefore: renewBefore}
+}
+
+// Start schedules renewal ahead of notAfter and runs it in the background
+// until the returned stop func is called. Each renewal reschedules itself
+// against the freshly issued certificate's own expiry.
+func (r *Renewer) Start(ctx context.Context, notAfter time.Time) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	go r.loop(ctx, notAfter)
+	return cancel
+}
+
+func (r *Renewer) loop(ctx context.Context, notAfter time.Time) {
+	for {
+		wait := time.Until(notAfter.Add(-r.RenewBefore))
+		if wait < 0 {
+			wait = 0
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		cert, err := r.Provisioner.RequestCertificate(ctx)
+		if err != nil {
+			// Back off and try again before the cert actually expires
+			// rather than giving up the renewal loop entirely. The next
+			// wait is notAfter minus RenewBefore, i.e. RenewBefore/2.
+			notAfter = time.Now().Add(r.RenewBefore + r.RenewBefore/2)
+			continue
+		}
+		if err := writeAtomic(r.CertPath, cert.CertPEM); err != nil {
+			notAfter = time.Now().Add(r.RenewBefore + r.RenewBefore/2)
+			continue
+		}
+		if err := writeAtomic(r.KeyPath, cert.KeyPEM); err != nil {
+			notAfter = time.Now().Add(r.RenewBefore + r.RenewBefore/2)
+			continue
+		}
+		notAfter = cert.NotAfter
+	}
+}
+
+// writeAtomic writes data to path by writing to a temp file in the same
+// directory and renaming it into place, so a concurrently starting broker
+// never observes a partially written cert or key.
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temp file for %s: %s", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %s", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing %s: %s", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("renaming into %s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenewerRenewsBeforeExpiry(t *testing.T) {
+	srv := newMockCA(t, true)
+
+	p := NewProvisioner(srv.URL+"/directory", "ops@example.com", "broker-0.example.com", fmt.Sprintf(":%d", freePort(t)))
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certPath, []byte("placeholder-cert"), 0644))
+	require.NoError(t, os.WriteFile(keyPath, []byte("placeholder-key"), 0644))
+
+	renewBefore := 50 * time.Millisecond
+	r := NewRenewer(p, certPath, keyPath, renewBefore)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := r.Start(ctx, time.Now().Add(renewBefore+20*time.Millisecond))
+	defer stop()
+
+	require.Eventually(t, func() bool {
+		certOut, err := os.ReadFile(certPath)
+		if err != nil {
+			return false
+		}
+		return string(certOut) != "placeholder-cert"
+	}, 2*time.Second, 10*time.Millisecond, "renewal never rewrote the cert file")
+
+	certOut, err := os.ReadFile(certPath)
+	require.NoError(t, err)
+	block, _ := pem.Decode(certOut)
+	require.NotNil(t, block)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"broker-0.example.com"}, leaf.DNSNames)
+
+	keyOut, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+	assert.NotEqual(t, "placeholder-key", string(keyOut))
+}
@@ -0,0 +1,238 @@
+// Package config loads the broker's security configuration from a single
+// YAML or TOML file instead of the dozen-plus KAFKA_* environment
+// variables setup-helper otherwise reads directly. It follows the
+// Load/override/Validate shape common to small Go config libraries: Load
+// parses the file, layers any KAFKA_* environment variables on top of it,
+// then validates the result before handing it back to the caller.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	portsBrokerEnvvar     = "KAFKA_BROKER_PORT"
+	portsBrokerTLSEnvvar  = "KAFKA_BROKER_PORT_TLS"
+	tlsEnabledEnvvar      = "KAFKA_SECURITY_TRANSPORT_ENCRYPTION_ENABLED"
+	tlsAllowPlainEnvvar   = "KAFKA_SECURITY_TRANSPORT_ENCRYPTION_ALLOW_PLAINTEXT"
+	tlsCertFileEnvvar     = "KAFKA_TLS_CERT_FILE"
+	tlsKeyFileEnvvar      = "KAFKA_TLS_KEY_FILE"
+	kerberosEnabledEnvvar = "KAFKA_SECURITY_KERBEROS_ENABLED"
+	kerberosPrimaryEnvvar = "KAFKA_SECURITY_KERBEROS_PRIMARY"
+	listenersSpecEnvvar   = "KAFKA_LISTENERS_SPEC"
+	saslMechanismsEnvvar  = "KAFKA_SASL_MECHANISMS"
+
+	gssapiMechanism = "GSSAPI"
+)
+
+// Config is the typed broker configuration this package knows how to load,
+// override and validate.
+type Config struct {
+	Ports       Ports       `yaml:"ports" toml:"ports"`
+	TLS         TLS         `yaml:"tls" toml:"tls"`
+	Kerberos    Kerberos    `yaml:"kerberos" toml:"kerberos"`
+	Listeners   Listeners   `yaml:"listeners" toml:"listeners"`
+	InterBroker InterBroker `yaml:"inter_broker" toml:"inter_broker"`
+}
+
+// Ports holds the plaintext/TLS broker port pair.
+type Ports struct {
+	Broker    string `yaml:"broker" toml:"broker"`
+	BrokerTLS string `yaml:"broker_tls" toml:"broker_tls"`
+}
+
+// TLS holds whether transport encryption is on, whether a plaintext
+// listener may coexist with it, and where the cert/key live on disk.
+type TLS struct {
+	Enabled        bool   `yaml:"enabled" toml:"enabled"`
+	AllowPlaintext bool   `yaml:"allow_plaintext" toml:"allow_plaintext"`
+	CertFile       string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile        string `yaml:"key_file" toml:"key_file"`
+}
+
+// Kerberos holds whether GSSAPI is enabled and the primary to authenticate
+// as.
+type Kerberos struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled"`
+	Primary string `yaml:"primary" toml:"primary"`
+}
+
+// Listeners holds a raw KAFKA_LISTENERS_SPEC-shaped value (JSON array or
+// comma-separated tuples); setup-helper parses it the same way regardless
+// of whether it came from the environment or this file.
+type Listeners struct {
+	Spec string `yaml:"spec" toml:"spec"`
+}
+
+// InterBroker holds the comma-separated SASL mechanisms setup-helper
+// should enable.
+type InterBroker struct {
+	SaslMechanisms string `yaml:"sasl_mechanisms" toml:"sasl_mechanisms"`
+}
+
+// Load reads the broker config file at path, overrides it with any
+// KAFKA_* environment variables that are set, validates the result, and
+// returns it. The file format is chosen by extension: ".yaml"/".yml" is
+// parsed as YAML, ".toml" as TOML.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s as YAML: %s", path, err)
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s as TOML: %s", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config extension %q: expected .yaml, .yml or .toml", ext)
+	}
+
+	cfg.overrideWithEnvVars()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// overrideWithEnvVars layers any set KAFKA_* environment variables on top
+// of the values parsed from the config file, so operators can ship one
+// file and still patch individual settings per-task via the environment.
+func (c *Config) overrideWithEnvVars() {
+	if v := os.Getenv(portsBrokerEnvvar); v != "" {
+		c.Ports.Broker = v
+	}
+	if v := os.Getenv(portsBrokerTLSEnvvar); v != "" {
+		c.Ports.BrokerTLS = v
+	}
+	if v, ok := parseBoolEnvvar(tlsEnabledEnvvar); ok {
+		c.TLS.Enabled = v
+	}
+	if v, ok := parseBoolEnvvar(tlsAllowPlainEnvvar); ok {
+		c.TLS.AllowPlaintext = v
+	}
+	if v := os.Getenv(tlsCertFileEnvvar); v != "" {
+		c.TLS.CertFile = v
+	}
+	if v := os.Getenv(tlsKeyFileEnvvar); v != "" {
+		c.TLS.KeyFile = v
+	}
+	if v, ok := parseBoolEnvvar(kerberosEnabledEnvvar); ok {
+		c.Kerberos.Enabled = v
+	}
+	if v := os.Getenv(kerberosPrimaryEnvvar); v != "" {
+		c.Kerberos.Primary = v
+	}
+	if v := os.Getenv(listenersSpecEnvvar); v != "" {
+		c.Listeners.Spec = v
+	}
+	if v := os.Getenv(saslMechanismsEnvvar); v != "" {
+		c.InterBroker.SaslMechanisms = v
+	}
+}
+
+// parseBoolEnvvar reports the boolean value of envvar and whether it was
+// set to something parseable at all, so overrideWithEnvVars can tell "not
+// set" apart from "explicitly false".
+func parseBoolEnvvar(envvar string) (value bool, ok bool) {
+	raw := os.Getenv(envvar)
+	if raw == "" {
+		return false, false
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, false
+	}
+	return v, true
+}
+
+// Validate checks that the resolved configuration is internally
+// consistent: TLS cert/key files exist when TLS is enabled, ports parse as
+// in-range integers, the Kerberos primary is set whenever GSSAPI is
+// enabled, and plaintext fallback isn't silently paired with a
+// Kerberos-only cluster.
+func (c *Config) Validate() error {
+	if c.TLS.Enabled {
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			return fmt.Errorf("tls.enabled is true but cert_file/key_file are not set")
+		}
+		if _, err := os.Stat(c.TLS.CertFile); err != nil {
+			return fmt.Errorf("tls.cert_file %q: %s", c.TLS.CertFile, err)
+		}
+		if _, err := os.Stat(c.TLS.KeyFile); err != nil {
+			return fmt.Errorf("tls.key_file %q: %s", c.TLS.KeyFile, err)
+		}
+	}
+
+	ports := map[string]string{
+		"ports.broker":     c.Ports.Broker,
+		"ports.broker_tls": c.Ports.BrokerTLS,
+	}
+	for name, port := range ports {
+		if port == "" {
+			continue
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not an integer", name, port)
+		}
+		if n < 1 || n > 65535 {
+			return fmt.Errorf("%s: %d is out of range 1-65535", name, n)
+		}
+	}
+
+	mechanisms := splitMechanisms(c.InterBroker.SaslMechanisms)
+	gssapiEnabled := c.Kerberos.Enabled
+	for _, m := range mechanisms {
+		if m == gssapiMechanism {
+			gssapiEnabled = true
+		}
+	}
+	if gssapiEnabled && c.Kerberos.Primary == "" {
+		return fmt.Errorf("GSSAPI is enabled but kerberos.primary is not set")
+	}
+	if onlyMechanismIsGSSAPI(mechanisms, c.Kerberos.Enabled) && c.TLS.AllowPlaintext {
+		return fmt.Errorf("tls.allow_plaintext cannot be combined with a Kerberos-only (GSSAPI) cluster")
+	}
+
+	return nil
+}
+
+func splitMechanisms(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+	var mechanisms []string
+	for _, m := range strings.Split(raw, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			mechanisms = append(mechanisms, m)
+		}
+	}
+	return mechanisms
+}
+
+// onlyMechanismIsGSSAPI reports whether the cluster's only SASL mechanism
+// is Kerberos: either KAFKA_SASL_MECHANISMS is exactly "GSSAPI", or it's
+// unset and the legacy kerberos.enabled flag is the sole thing turning
+// SASL on.
+func onlyMechanismIsGSSAPI(mechanisms []string, kerberosEnabled bool) bool {
+	if len(mechanisms) == 0 {
+		return kerberosEnabled
+	}
+	return len(mechanisms) == 1 && mechanisms[0] == gssapiMechanism
+}
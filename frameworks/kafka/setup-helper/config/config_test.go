@@ -0,0 +1,172 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFixture(t *testing.T, name string, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadYAML(t *testing.T) {
+	asrt := assert.New(t)
+	os.Clearenv()
+
+	path := writeFixture(t, "broker.yaml", `
+ports:
+  broker: "9092"
+  broker_tls: "9093"
+kerberos:
+  enabled: true
+  primary: kafka
+inter_broker:
+  sasl_mechanisms: GSSAPI
+`)
+
+	cfg, err := Load(path)
+	asrt.NoError(err)
+	asrt.Equal("9092", cfg.Ports.Broker)
+	asrt.Equal("9093", cfg.Ports.BrokerTLS)
+	asrt.True(cfg.Kerberos.Enabled)
+	asrt.Equal("kafka", cfg.Kerberos.Primary)
+}
+
+func TestLoadTOML(t *testing.T) {
+	asrt := assert.New(t)
+	os.Clearenv()
+
+	path := writeFixture(t, "broker.toml", `
+[ports]
+broker = "9092"
+broker_tls = "9093"
+
+[kerberos]
+enabled = true
+primary = "kafka"
+
+[inter_broker]
+sasl_mechanisms = "GSSAPI"
+`)
+
+	cfg, err := Load(path)
+	asrt.NoError(err)
+	asrt.Equal("9092", cfg.Ports.Broker)
+	asrt.True(cfg.Kerberos.Enabled)
+	asrt.Equal("kafka", cfg.Kerberos.Primary)
+}
+
+func TestLoadUnsupportedExtension(t *testing.T) {
+	asrt := assert.New(t)
+	path := writeFixture(t, "broker.ini", "broker=9092")
+
+	_, err := Load(path)
+	asrt.Error(err)
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	asrt := assert.New(t)
+	_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	asrt.Error(err)
+}
+
+func TestOverrideWithEnvVars(t *testing.T) {
+	asrt := assert.New(t)
+	os.Clearenv()
+
+	path := writeFixture(t, "broker.yaml", `
+ports:
+  broker: "9092"
+kerberos:
+  enabled: false
+`)
+
+	os.Setenv(portsBrokerEnvvar, "9192")
+	os.Setenv(kerberosEnabledEnvvar, "true")
+	os.Setenv(kerberosPrimaryEnvvar, "kafka")
+	os.Setenv(saslMechanismsEnvvar, "GSSAPI")
+	defer os.Clearenv()
+
+	cfg, err := Load(path)
+	asrt.NoError(err)
+	asrt.Equal("9192", cfg.Ports.Broker)
+	asrt.True(cfg.Kerberos.Enabled)
+}
+
+func TestValidate(t *testing.T) {
+	certFile := writeFixture(t, "cert.pem", "cert")
+	keyFile := writeFixture(t, "key.pem", "key")
+
+	tests := []struct {
+		name          string
+		cfg           Config
+		errorExpected bool
+	}{
+		{
+			name: "valid plaintext",
+			cfg:  Config{Ports: Ports{Broker: "9092"}},
+		},
+		{
+			name: "tls enabled with existing files",
+			cfg: Config{
+				Ports: Ports{BrokerTLS: "9093"},
+				TLS:   TLS{Enabled: true, CertFile: certFile, KeyFile: keyFile},
+			},
+		},
+		{
+			name: "tls enabled with missing cert",
+			cfg: Config{
+				TLS: TLS{Enabled: true, CertFile: "/does/not/exist.pem", KeyFile: keyFile},
+			},
+			errorExpected: true,
+		},
+		{
+			name:          "port out of range",
+			cfg:           Config{Ports: Ports{Broker: "99999"}},
+			errorExpected: true,
+		},
+		{
+			name:          "port not an integer",
+			cfg:           Config{Ports: Ports{Broker: "nope"}},
+			errorExpected: true,
+		},
+		{
+			name:          "GSSAPI without kerberos primary",
+			cfg:           Config{InterBroker: InterBroker{SaslMechanisms: "GSSAPI"}},
+			errorExpected: true,
+		},
+		{
+			name: "kerberos-only cluster with plaintext fallback",
+			cfg: Config{
+				Kerberos: Kerberos{Enabled: true, Primary: "kafka"},
+				TLS:      TLS{AllowPlaintext: true},
+			},
+			errorExpected: true,
+		},
+		{
+			name: "GSSAPI plus SCRAM with plaintext fallback is fine",
+			cfg: Config{
+				Kerberos:    Kerberos{Enabled: true, Primary: "kafka"},
+				InterBroker: InterBroker{SaslMechanisms: "GSSAPI,SCRAM-SHA-512"},
+				TLS:         TLS{AllowPlaintext: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		err := test.cfg.Validate()
+		if test.errorExpected {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		assert.NoError(t, err, test.name)
+	}
+}
@@ -0,0 +1,700 @@
+// Command setup-helper runs once before the Kafka broker process starts,
+// translating DC/OS task environment variables into the handful of
+// property files that the broker's start script stitches into
+// server.properties. It exists because Kafka's listener/security
+// configuration depends on values (pod IP, task name, advertised host)
+// that are only known at task runtime, not at build time.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/dcos-commons/frameworks/kafka/setup-helper/acme"
+	"github.com/mesosphere/dcos-commons/frameworks/kafka/setup-helper/config"
+)
+
+const (
+	kerberosEnvvar        = "KAFKA_SECURITY_KERBEROS_ENABLED"
+	kerberosPrimaryEnvvar = "KAFKA_SECURITY_KERBEROS_PRIMARY"
+	tlsEncryptionEnvvar   = "KAFKA_SECURITY_TRANSPORT_ENCRYPTION_ENABLED"
+	tlsAllowPlainEnvvar   = "KAFKA_SECURITY_TRANSPORT_ENCRYPTION_ALLOW_PLAINTEXT"
+	brokerPort            = "KAFKA_BROKER_PORT"
+	brokerPortTLS         = "KAFKA_BROKER_PORT_TLS"
+	taskNameEnvvar        = "TASK_NAME"
+	frameworkHostEnvvar   = "FRAMEWORK_HOST"
+	ipEnvvar              = "MESOS_CONTAINER_IP"
+
+	// listenersSpecEnvvar, when set, switches setListeners() away from the
+	// legacy two-listener (plaintext/TLS) behavior and over to an arbitrary
+	// set of named listeners, each with its own protocol, port and
+	// advertised host. Accepts either a JSON array of objects or a
+	// comma-separated list of "name:protocol:port:advertised-host" tuples.
+	listenersSpecEnvvar = "KAFKA_LISTENERS_SPEC"
+
+	// defaultInterBrokerListenerName is used as inter.broker.listener.name
+	// when KAFKA_LISTENERS_SPEC defines a listener with this name;
+	// otherwise the first listener in the spec is used for broker-to-broker
+	// traffic.
+	defaultInterBrokerListenerName = "INTERNAL"
+
+	// saslMechanismsEnvvar is a comma-separated list drawn from
+	// saslMechanismGSSAPI/PLAIN/SCRAM-SHA-256/SCRAM-SHA-512/OAUTHBEARER.
+	// When unset, kerberosEnvvar is consulted as a shorthand for enabling
+	// GSSAPI alone, preserving the original Kerberos-only behavior.
+	saslMechanismsEnvvar = "KAFKA_SASL_MECHANISMS"
+
+	saslMechanismGSSAPI      = "GSSAPI"
+	saslMechanismPlain       = "PLAIN"
+	saslMechanismScram256    = "SCRAM-SHA-256"
+	saslMechanismScram512    = "SCRAM-SHA-512"
+	saslMechanismOAuthBearer = "OAUTHBEARER"
+
+	// configFileEnvvar, when set, points calculateSettings at a single YAML
+	// or TOML file (see the config package) instead of requiring every
+	// setting to be shipped as its own KAFKA_* environment variable.
+	configFileEnvvar = "KAFKA_CONFIG_FILE"
+
+	// acmeEnabledEnvvar, when true, provisions the broker's TLS certificate
+	// from an ACME directory instead of expecting one to already be on
+	// disk. acmeDirectoryURLEnvvar, acmeContactEmailEnvvar and
+	// acmeChallengePortEnvvar are required whenever it's set.
+	acmeEnabledEnvvar      = "KAFKA_TLS_ACME"
+	acmeDirectoryURLEnvvar = "KAFKA_TLS_ACME_DIRECTORY_URL"
+	acmeContactEmailEnvvar = "KAFKA_TLS_ACME_CONTACT_EMAIL"
+
+	// acmeChallengePortEnvvar is a port dedicated to the tls-alpn-01
+	// challenge listener, separate from brokerPortTLS. The initial
+	// provisioning in provisionACMECertificate runs before the broker
+	// binds its own TLS port, but runACMERenewalDaemon's renewals run
+	// while the broker is live -- reusing brokerPortTLS there would
+	// always fail with "address already in use".
+	acmeChallengePortEnvvar = "KAFKA_TLS_ACME_CHALLENGE_PORT"
+
+	acmeCertFile = "kafka-acme-cert.pem"
+	acmeKeyFile  = "kafka-acme-key.pem"
+
+	// acmeRenewBefore is how far ahead of a certificate's expiry the
+	// renewal daemon (see runACMERenewalDaemon) requests a replacement.
+	acmeRenewBefore = 30 * 24 * time.Hour
+
+	// sslClientAuthEnvvar selects whether brokers request or require a
+	// client certificate during the TLS handshake: "none" (the Kafka
+	// default), "requested" or "required". Left unset, no ssl.client.auth
+	// configuration is written at all, preserving prior behavior.
+	sslClientAuthEnvvar = "KAFKA_SSL_CLIENT_AUTH"
+
+	// sslPrincipalMappingRulesEnvvar is a comma-separated list of
+	// "RULE:pattern/replacement/[LU]" entries, passed through verbatim to
+	// ssl.principal.mapping.rules.
+	sslPrincipalMappingRulesEnvvar = "KAFKA_SSL_PRINCIPAL_MAPPING_RULES"
+)
+
+// validSslClientAuthValues is the set of values KAFKA_SSL_CLIENT_AUTH
+// accepts, matching Kafka's own ssl.client.auth setting.
+var validSslClientAuthValues = map[string]bool{
+	"none":      true,
+	"requested": true,
+	"required":  true,
+}
+
+// acmeState carries the Provisioner and certificate produced by
+// calculateSettings's initial ACME provisioning step through to main's
+// renewal daemon, so the daemon doesn't have to re-provision a brand new
+// certificate the moment the broker starts.
+var acmeState struct {
+	provisioner *acme.Provisioner
+	cert        *acme.Certificate
+}
+
+// validSaslMechanisms is the set of mechanism names KAFKA_SASL_MECHANISMS
+// accepts.
+var validSaslMechanisms = map[string]bool{
+	saslMechanismGSSAPI:      true,
+	saslMechanismPlain:       true,
+	saslMechanismScram256:    true,
+	saslMechanismScram512:    true,
+	saslMechanismOAuthBearer: true,
+}
+
+// listenerDescriptor is a single Kafka listener, fully resolved: the name
+// it's referred to by in listener.security.protocol.map, the security
+// protocol backing it, the port it binds on this broker, and the host
+// advertised to clients and other brokers.
+type listenerDescriptor struct {
+	name           string
+	protocol       string
+	port           string
+	advertisedHost string
+}
+
+// namedListenerSpec is the raw shape of one KAFKA_LISTENERS_SPEC entry,
+// before the advertised host has been defaulted.
+type namedListenerSpec struct {
+	Name           string `json:"name"`
+	Protocol       string `json:"protocol"`
+	Port           string `json:"port"`
+	AdvertisedHost string `json:"advertisedHost"`
+}
+
+func main() {
+	if err := calculateSettings(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if getBooleanEnvvar(acmeEnabledEnvvar) {
+		runACMERenewalDaemon()
+	}
+}
+
+// calculateSettings runs the full set of property-file generation steps
+// and is the single entry point invoked by main(). When KAFKA_CONFIG_FILE
+// is set, it's loaded (and validated) through the config package first;
+// the resulting settings are pushed back into the environment so the rest
+// of this file's env-var-driven logic picks them up unchanged.
+func calculateSettings() error {
+	if path := os.Getenv(configFileEnvvar); path != "" {
+		cfg, err := config.Load(path)
+		if err != nil {
+			return err
+		}
+		if err := applyConfig(cfg); err != nil {
+			return err
+		}
+	}
+
+	if getBooleanEnvvar(acmeEnabledEnvvar) {
+		if err := provisionACMECertificate(); err != nil {
+			return err
+		}
+	}
+
+	if err := setListeners(); err != nil {
+		return err
+	}
+	// setInterBrokerProtocol writes security.inter.broker.protocol, which
+	// Kafka refuses to start alongside inter.broker.listener.name --
+	// setNamedListeners already wrote the latter (and its own SASL
+	// config, if applicable) when KAFKA_LISTENERS_SPEC is set.
+	if os.Getenv(listenersSpecEnvvar) == "" {
+		if err := setInterBrokerProtocol(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// provisionACMECertificate requests the broker's initial TLS certificate
+// from an ACME directory and points ssl.keystore.location/
+// ssl.truststore.location at the resulting files, stashing the Provisioner
+// and issued certificate in acmeState for the renewal daemon to reuse.
+func provisionACMECertificate() error {
+	directoryURL := os.Getenv(acmeDirectoryURLEnvvar)
+	contactEmail := os.Getenv(acmeContactEmailEnvvar)
+	challengePort := os.Getenv(acmeChallengePortEnvvar)
+	if directoryURL == "" || contactEmail == "" || challengePort == "" {
+		return fmt.Errorf("%s requires %s, %s and %s to be set", acmeEnabledEnvvar, acmeDirectoryURLEnvvar, acmeContactEmailEnvvar, acmeChallengePortEnvvar)
+	}
+
+	domain := fmt.Sprintf("%s.%s", os.Getenv(taskNameEnvvar), os.Getenv(frameworkHostEnvvar))
+	// challengeAddr binds acmeChallengePortEnvvar, not brokerPortTLS: the
+	// broker itself owns brokerPortTLS for as long as this process runs,
+	// so a renewal (see runACMERenewalDaemon) could never bind it again.
+	challengeAddr := fmt.Sprintf(":%s", challengePort)
+	provisioner := acme.NewProvisioner(directoryURL, contactEmail, domain, challengeAddr)
+
+	cert, err := provisioner.RequestCertificate(context.Background())
+	if err != nil {
+		return fmt.Errorf("provisioning ACME certificate for %s: %s", domain, err)
+	}
+	if err := writeACMECertificate(cert); err != nil {
+		return err
+	}
+
+	acmeState.provisioner = provisioner
+	acmeState.cert = cert
+	return nil
+}
+
+// writeACMECertificate writes cert's PEM-encoded certificate and key to the
+// working directory and points ssl.keystore.location/
+// ssl.keystore.key.location/ssl.truststore.location at them.
+func writeACMECertificate(cert *acme.Certificate) error {
+	if err := writeToWorkingDirectory(acmeCertFile, string(cert.CertPEM)); err != nil {
+		return err
+	}
+	if err := writeToWorkingDirectory(acmeKeyFile, string(cert.KeyPEM)); err != nil {
+		return err
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	return writeKeystoreLocationConfig(path.Join(wd, acmeCertFile), path.Join(wd, acmeKeyFile))
+}
+
+// writeKeystoreLocationConfig points ssl.keystore.location/
+// ssl.keystore.key.location at certFile/keyFile. certFile also backs
+// ssl.truststore.location: the certificate chain returned by an ACME CA
+// (or shipped by an operator) already carries any intermediates needed to
+// validate it, so there's no separate CA-only bundle to point at instead.
+func writeKeystoreLocationConfig(certFile, keyFile string) error {
+	if err := writeToWorkingDirectory("ssl-keystore-location-config", "ssl.keystore.location="+certFile); err != nil {
+		return err
+	}
+	if err := writeToWorkingDirectory("ssl-keystore-key-location-config", "ssl.keystore.key.location="+keyFile); err != nil {
+		return err
+	}
+	return writeToWorkingDirectory("ssl-truststore-location-config", "ssl.truststore.location="+certFile)
+}
+
+// runACMERenewalDaemon keeps the broker's ACME certificate current in the
+// background for as long as this process stays up, so renewal doesn't
+// require a dedicated sidecar. It reuses the Provisioner and certificate
+// calculateSettings already obtained. Callers that want renewal are
+// expected to background this binary (e.g. "setup-helper &") before
+// starting the broker, since this call blocks forever.
+func runACMERenewalDaemon() {
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	renewer := acme.NewRenewer(acmeState.provisioner, path.Join(wd, acmeCertFile), path.Join(wd, acmeKeyFile), acmeRenewBefore)
+	stop := renewer.Start(context.Background(), acmeState.cert.NotAfter)
+	defer stop()
+
+	select {} // block forever; the wrapper script backgrounds this process
+}
+
+// applyConfig copies a loaded config.Config (already reconciled with any
+// KAFKA_* environment overrides by config.Load) back into the process
+// environment, and, when a TLS cert/key pair was configured, writes
+// ssl.keystore.location/ssl.truststore.location to point at them.
+func applyConfig(cfg *config.Config) error {
+	os.Setenv(brokerPort, cfg.Ports.Broker)
+	os.Setenv(brokerPortTLS, cfg.Ports.BrokerTLS)
+	os.Setenv(tlsEncryptionEnvvar, strconv.FormatBool(cfg.TLS.Enabled))
+	os.Setenv(tlsAllowPlainEnvvar, strconv.FormatBool(cfg.TLS.AllowPlaintext))
+	os.Setenv(kerberosEnvvar, strconv.FormatBool(cfg.Kerberos.Enabled))
+	if cfg.Kerberos.Primary != "" {
+		os.Setenv(kerberosPrimaryEnvvar, cfg.Kerberos.Primary)
+	}
+	if cfg.Listeners.Spec != "" {
+		os.Setenv(listenersSpecEnvvar, cfg.Listeners.Spec)
+	}
+	if cfg.InterBroker.SaslMechanisms != "" {
+		os.Setenv(saslMechanismsEnvvar, cfg.InterBroker.SaslMechanisms)
+	}
+
+	if cfg.TLS.CertFile == "" && cfg.TLS.KeyFile == "" {
+		return nil
+	}
+	// When tls.enabled is true, config.Validate has already confirmed
+	// these paths exist on disk; otherwise they are written unchecked.
+	// writeKeystoreLocationConfig is the same helper
+	// writeACMECertificate uses, so cfg.TLS.KeyFile gets wired into
+	// ssl.keystore.key.location exactly like an ACME-issued key does.
+	return writeKeystoreLocationConfig(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+}
+
+// setListeners writes listeners-config and advertised-listeners-config
+// (and, when KAFKA_LISTENERS_SPEC is set, listener.security.protocol.map
+// and inter.broker.listener.name alongside them). With no spec present it
+// falls back to the original plaintext/TLS pair driven by the Kerberos and
+// TLS envvars.
+func setListeners() error {
+	if spec := os.Getenv(listenersSpecEnvvar); spec != "" {
+		return setNamedListeners(spec)
+	}
+
+	mechanisms, err := resolveSaslMechanisms()
+	if err != nil {
+		return err
+	}
+	saslEnabled := len(mechanisms) > 0
+	tlsEnabled, err := parseBoolEnvvar(tlsEncryptionEnvvar)
+	if err != nil {
+		return err
+	}
+	tlsAllowPlain, err := parseBoolEnvvar(tlsAllowPlainEnvvar)
+	if err != nil {
+		return err
+	}
+
+	var descriptors []listenerDescriptor
+	if tlsEnabled {
+		protocol := "SSL"
+		if saslEnabled {
+			protocol = "SASL_SSL"
+		}
+		descriptors = append(descriptors, listenerDescriptor{
+			name:     protocol,
+			protocol: protocol,
+			port:     os.Getenv(brokerPortTLS),
+		})
+	}
+	if !tlsEnabled || tlsAllowPlain {
+		protocol := "PLAINTEXT"
+		if saslEnabled {
+			protocol = "SASL_PLAINTEXT"
+		}
+		descriptors = append(descriptors, listenerDescriptor{
+			name:     protocol,
+			protocol: protocol,
+			port:     os.Getenv(brokerPort),
+		})
+	}
+
+	if err := writeListeners(descriptors); err != nil {
+		return err
+	}
+	return writeSSLClientAuth(descriptors, tlsAllowPlain)
+}
+
+// setNamedListeners resolves a KAFKA_LISTENERS_SPEC value into listener
+// descriptors and writes the full set of multi-listener property files.
+func setNamedListeners(spec string) error {
+	specs, err := parseListenersSpec(spec)
+	if err != nil {
+		return err
+	}
+	if len(specs) == 0 {
+		return fmt.Errorf("%s is set but defines no listeners", listenersSpecEnvvar)
+	}
+
+	descriptors := make([]listenerDescriptor, 0, len(specs))
+	protocolMap := make([]string, 0, len(specs))
+	interBrokerListener := ""
+	for _, s := range specs {
+		if s.Name == "" || s.Protocol == "" {
+			return fmt.Errorf("listener spec entry %+v is missing a name or protocol", s)
+		}
+		if _, err := strconv.Atoi(s.Port); err != nil {
+			return fmt.Errorf("listener %q has non-numeric port %q", s.Name, s.Port)
+		}
+
+		descriptors = append(descriptors, listenerDescriptor{
+			name:           s.Name,
+			protocol:       s.Protocol,
+			port:           s.Port,
+			advertisedHost: s.AdvertisedHost,
+		})
+		protocolMap = append(protocolMap, fmt.Sprintf("%s:%s", s.Name, s.Protocol))
+		if strings.EqualFold(s.Name, defaultInterBrokerListenerName) {
+			interBrokerListener = s.Name
+		}
+	}
+	if interBrokerListener == "" {
+		interBrokerListener = descriptors[0].name
+	}
+
+	if err := writeListeners(descriptors); err != nil {
+		return err
+	}
+	if err := writeToWorkingDirectory("listener-security-protocol-map-config", "listener.security.protocol.map="+strings.Join(protocolMap, ",")); err != nil {
+		return err
+	}
+	if err := writeToWorkingDirectory("inter-broker-listener-name-config", "inter.broker.listener.name="+interBrokerListener); err != nil {
+		return err
+	}
+	var interBrokerProtocol string
+	for _, d := range descriptors {
+		if d.name == interBrokerListener {
+			interBrokerProtocol = d.protocol
+			break
+		}
+	}
+	if err := setNamedListenerInterBrokerSasl(interBrokerProtocol); err != nil {
+		return err
+	}
+	// KAFKA_SECURITY_TRANSPORT_ENCRYPTION_ALLOW_PLAINTEXT doesn't apply to
+	// named listener specs: each listener's own protocol already says
+	// whether it's encrypted, so there's no single "plaintext fallback"
+	// listener to reject client.auth=required against.
+	return writeSSLClientAuth(descriptors, false)
+}
+
+// setNamedListenerInterBrokerSasl writes the SASL-specific inter-broker
+// property files for named listener specs, derived from the inter-broker
+// listener's own protocol rather than a global security.inter.broker.protocol
+// setting. It deliberately never writes security.inter.broker.protocol
+// itself: Kafka refuses to start if that's set alongside
+// inter.broker.listener.name, which setNamedListeners already wrote.
+func setNamedListenerInterBrokerSasl(interBrokerProtocol string) error {
+	if !strings.Contains(interBrokerProtocol, "SASL") {
+		return nil
+	}
+	mechanisms, err := resolveSaslMechanisms()
+	if err != nil {
+		return err
+	}
+	if len(mechanisms) == 0 {
+		return nil
+	}
+	return writeSaslMechanismConfig(mechanisms)
+}
+
+// parseListenersSpec accepts either a JSON array of listener objects or a
+// comma-separated list of "name:protocol:port:advertised-host" tuples.
+func parseListenersSpec(spec string) ([]namedListenerSpec, error) {
+	trimmed := strings.TrimSpace(spec)
+	if strings.HasPrefix(trimmed, "[") {
+		var specs []namedListenerSpec
+		if err := json.Unmarshal([]byte(trimmed), &specs); err != nil {
+			return nil, fmt.Errorf("invalid %s JSON: %s", listenersSpecEnvvar, err)
+		}
+		return specs, nil
+	}
+
+	var specs []namedListenerSpec
+	for _, entry := range strings.Split(trimmed, ",") {
+		fields := strings.Split(entry, ":")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid listener tuple %q: expected name:protocol:port:advertised-host", entry)
+		}
+		specs = append(specs, namedListenerSpec{
+			Name:           fields[0],
+			Protocol:       fields[1],
+			Port:           fields[2],
+			AdvertisedHost: fields[3],
+		})
+	}
+	return specs, nil
+}
+
+// writeListeners renders descriptors into listeners-config and
+// advertised-listeners-config, in the order given.
+func writeListeners(descriptors []listenerDescriptor) error {
+	listeners := make([]string, 0, len(descriptors))
+	advertised := make([]string, 0, len(descriptors))
+	for _, d := range descriptors {
+		listeners = append(listeners, getListener(d))
+		advertised = append(advertised, getAdvertisedListener(d))
+	}
+
+	if err := writeToWorkingDirectory("listeners-config", "listeners="+strings.Join(listeners, ",")); err != nil {
+		return err
+	}
+	return writeToWorkingDirectory("advertised-listeners-config", "advertised.listeners="+strings.Join(advertised, ","))
+}
+
+// writeSSLClientAuth writes ssl.client.auth, ssl.principal.mapping.rules and
+// a listener.name.<name>.ssl.client.auth override for every SSL or
+// SASL_SSL listener in descriptors. It's a no-op when KAFKA_SSL_CLIENT_AUTH
+// isn't set, preserving the original listener behavior. allowPlaintext
+// reflects KAFKA_SECURITY_TRANSPORT_ENCRYPTION_ALLOW_PLAINTEXT for the
+// legacy two-listener setup; callers for which that envvar doesn't apply
+// (e.g. named listener specs) should pass false.
+func writeSSLClientAuth(descriptors []listenerDescriptor, allowPlaintext bool) error {
+	clientAuth := os.Getenv(sslClientAuthEnvvar)
+	if clientAuth == "" {
+		return nil
+	}
+	if !validSslClientAuthValues[clientAuth] {
+		return fmt.Errorf("unsupported %s value %q", sslClientAuthEnvvar, clientAuth)
+	}
+	if clientAuth == "required" && allowPlaintext {
+		return fmt.Errorf("%s=required cannot be combined with %s=true", sslClientAuthEnvvar, tlsAllowPlainEnvvar)
+	}
+
+	if err := writeToWorkingDirectory("ssl-client-auth-config", "ssl.client.auth="+clientAuth); err != nil {
+		return err
+	}
+
+	if rules := os.Getenv(sslPrincipalMappingRulesEnvvar); rules != "" {
+		if err := writeToWorkingDirectory("ssl-principal-mapping-rules-config", "ssl.principal.mapping.rules="+rules); err != nil {
+			return err
+		}
+	}
+
+	var overrides []string
+	for _, d := range descriptors {
+		if d.protocol != "SSL" && d.protocol != "SASL_SSL" {
+			continue
+		}
+		overrides = append(overrides, fmt.Sprintf("listener.name.%s.ssl.client.auth=%s", strings.ToLower(d.name), clientAuth))
+	}
+	if len(overrides) == 0 {
+		return nil
+	}
+	return writeToWorkingDirectory("listener-ssl-client-auth-config", strings.Join(overrides, "\n"))
+}
+
+// getListener renders the bind address for a single listener, e.g.
+// "SASL_SSL://10.0.0.1:9094".
+func getListener(d listenerDescriptor) string {
+	return fmt.Sprintf("%s://%s:%s", d.name, os.Getenv(ipEnvvar), d.port)
+}
+
+// getAdvertisedListener renders the address a listener is advertised under,
+// falling back to "${TASK_NAME}.${FRAMEWORK_HOST}" when the descriptor
+// doesn't specify its own advertised host.
+func getAdvertisedListener(d listenerDescriptor) string {
+	host := d.advertisedHost
+	if host == "" {
+		host = fmt.Sprintf("%s.%s", os.Getenv(taskNameEnvvar), os.Getenv(frameworkHostEnvvar))
+	}
+	return fmt.Sprintf("%s://%s:%s", d.name, host, d.port)
+}
+
+// setInterBrokerProtocol writes security.inter.broker.protocol based on the
+// resolved SASL mechanisms and the TLS envvar, and, when SASL is enabled,
+// the accompanying sasl.enabled.mechanisms, sasl.mechanism.inter.broker.protocol
+// and kafka_server_jaas.conf fragments. It only applies to the legacy
+// two-listener path; setNamedListeners derives inter.broker.listener.name
+// (and its own SASL config, via setNamedListenerInterBrokerSasl) itself.
+func setInterBrokerProtocol() error {
+	mechanisms, err := resolveSaslMechanisms()
+	if err != nil {
+		return err
+	}
+	saslEnabled := len(mechanisms) > 0
+	tlsEnabled, err := parseBoolEnvvar(tlsEncryptionEnvvar)
+	if err != nil {
+		return err
+	}
+
+	var protocol string
+	switch {
+	case saslEnabled && tlsEnabled:
+		protocol = "SASL_SSL"
+	case saslEnabled && !tlsEnabled:
+		protocol = "SASL_PLAINTEXT"
+	case !saslEnabled && tlsEnabled:
+		protocol = "SSL"
+	default:
+		protocol = "PLAINTEXT"
+	}
+
+	if err := writeToWorkingDirectory("security.inter.broker.protocol", "security.inter.broker.protocol="+protocol); err != nil {
+		return err
+	}
+	if !saslEnabled {
+		return nil
+	}
+	return writeSaslMechanismConfig(mechanisms)
+}
+
+// writeSaslMechanismConfig writes sasl.enabled.mechanisms,
+// sasl.mechanism.inter.broker.protocol and kafka_server_jaas.conf for the
+// given mechanisms. Shared by the legacy setInterBrokerProtocol path and
+// setNamedListenerInterBrokerSasl.
+func writeSaslMechanismConfig(mechanisms []string) error {
+	if err := writeToWorkingDirectory("sasl-enabled-mechanisms-config", "sasl.enabled.mechanisms="+strings.Join(mechanisms, ",")); err != nil {
+		return err
+	}
+	if err := writeToWorkingDirectory("sasl-mechanism-inter-broker-protocol-config", "sasl.mechanism.inter.broker.protocol="+interBrokerSaslMechanism(mechanisms)); err != nil {
+		return err
+	}
+	return writeToWorkingDirectory("kafka_server_jaas.conf", saslJaasConfig(mechanisms))
+}
+
+// resolveSaslMechanisms returns the set of enabled SASL mechanisms.
+// KAFKA_SASL_MECHANISMS takes precedence; with it unset, kerberosEnvvar is
+// used as a shorthand for enabling GSSAPI alone.
+func resolveSaslMechanisms() ([]string, error) {
+	if raw := os.Getenv(saslMechanismsEnvvar); raw != "" {
+		var mechanisms []string
+		for _, m := range strings.Split(raw, ",") {
+			m = strings.TrimSpace(m)
+			if !validSaslMechanisms[m] {
+				return nil, fmt.Errorf("unsupported SASL mechanism %q in %s", m, saslMechanismsEnvvar)
+			}
+			mechanisms = append(mechanisms, m)
+		}
+		return mechanisms, nil
+	}
+
+	kerberosEnabled, err := parseBoolEnvvar(kerberosEnvvar)
+	if err != nil {
+		return nil, err
+	}
+	if kerberosEnabled {
+		return []string{saslMechanismGSSAPI}, nil
+	}
+	return nil, nil
+}
+
+// interBrokerSaslMechanism picks which of the enabled mechanisms secures
+// broker-to-broker traffic, preferring GSSAPI (Kerberos) when present since
+// it's the mechanism DC/OS clusters have historically relied on for
+// inter-broker auth.
+func interBrokerSaslMechanism(mechanisms []string) string {
+	for _, m := range mechanisms {
+		if m == saslMechanismGSSAPI {
+			return m
+		}
+	}
+	return mechanisms[0]
+}
+
+// saslJaasConfig renders a kafka_server_jaas.conf KafkaServer section with
+// one login module per distinct mechanism family.
+func saslJaasConfig(mechanisms []string) string {
+	var modules []string
+	seen := map[string]bool{}
+	for _, m := range mechanisms {
+		var module string
+		switch m {
+		case saslMechanismGSSAPI:
+			module = fmt.Sprintf("com.sun.security.auth.module.Krb5LoginModule required\n        useKeyTab=true\n        storeKey=true\n        keyTab=\"kafka.keytab\"\n        principal=\"%s\";", os.Getenv(kerberosPrimaryEnvvar))
+		case saslMechanismPlain:
+			module = "org.apache.kafka.common.security.plain.PlainLoginModule required;"
+		case saslMechanismScram256, saslMechanismScram512:
+			module = "org.apache.kafka.common.security.scram.ScramLoginModule required;"
+		case saslMechanismOAuthBearer:
+			module = "org.apache.kafka.common.security.oauthbearer.OAuthBearerLoginModule required;"
+		default:
+			continue
+		}
+		if seen[module] {
+			continue
+		}
+		seen[module] = true
+		modules = append(modules, module)
+	}
+	return fmt.Sprintf("KafkaServer {\n    %s\n};\n", strings.Join(modules, "\n    "))
+}
+
+// getBooleanEnvvar returns the parsed boolean value of envvar, defaulting
+// to false when it's unset or unparseable. Callers that need to surface a
+// bad value as an error should use parseBoolEnvvar instead.
+func getBooleanEnvvar(envvar string) bool {
+	val, err := strconv.ParseBool(os.Getenv(envvar))
+	if err != nil {
+		return false
+	}
+	return val
+}
+
+// parseBoolEnvvar parses envvar as a boolean, treating an unset value as
+// false and returning an error if it's set to something that isn't a valid
+// boolean.
+func parseBoolEnvvar(envvar string) (bool, error) {
+	val := os.Getenv(envvar)
+	if val == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(val)
+}
+
+// writeToWorkingDirectory writes content to a file named filename in the
+// current working directory, the convention the broker's start script
+// expects for generated property fragments.
+func writeToWorkingDirectory(filename string, content string) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(wd, filename), []byte(content), 0644)
+}
@@ -4,6 +4,8 @@ import "testing"
 import "os"
 import "path"
 import "io/ioutil"
+import "time"
+import "github.com/mesosphere/dcos-commons/frameworks/kafka/setup-helper/acme"
 import "github.com/stretchr/testify/assert"
 import "log"
 
@@ -39,13 +41,154 @@ func TestCalculateSettingsListenersError(t *testing.T) {
 	asrt.Error(calculateSettings())
 }
 
+func TestCalculateSettingsFromConfigFile(t *testing.T) {
+	asrt := assert.New(t)
+
+	os.Clearenv()
+	setEnv(taskNameEnvvar, "a-task")
+	setEnv(frameworkHostEnvvar, "a-framework")
+	setEnv(ipEnvvar, "127.0.0.1")
+
+	wd, _ := os.Getwd()
+	configPath := path.Join(wd, "broker.yaml")
+	asrt.NoError(ioutil.WriteFile(configPath, []byte(`
+ports:
+  broker: "2000"
+kerberos:
+  enabled: false
+`), 0644))
+	defer os.Remove(configPath)
+
+	setEnv(configFileEnvvar, configPath)
+
+	defer func() {
+		cleanUpWDFile("listeners-config")
+		cleanUpWDFile("advertised-listeners-config")
+		cleanUpWDFile("security.inter.broker.protocol")
+	}()
+
+	asrt.NoError(calculateSettings())
+
+	out, err := readWDFile("listeners-config")
+	asrt.NoError(err)
+	asrt.Equal("listeners=PLAINTEXT://127.0.0.1:2000", string(out))
+}
+
+func TestCalculateSettingsFromConfigFileWritesKeystore(t *testing.T) {
+	asrt := assert.New(t)
+
+	os.Clearenv()
+	setEnv(taskNameEnvvar, "a-task")
+	setEnv(frameworkHostEnvvar, "a-framework")
+	setEnv(ipEnvvar, "127.0.0.1")
+
+	wd, _ := os.Getwd()
+	certPath := path.Join(wd, "broker.crt")
+	keyPath := path.Join(wd, "broker.key")
+	asrt.NoError(ioutil.WriteFile(certPath, []byte("cert"), 0644))
+	asrt.NoError(ioutil.WriteFile(keyPath, []byte("key"), 0644))
+	defer os.Remove(certPath)
+	defer os.Remove(keyPath)
+
+	configPath := path.Join(wd, "broker.yaml")
+	asrt.NoError(ioutil.WriteFile(configPath, []byte(`
+ports:
+  broker: "2000"
+  broker_tls: "2001"
+tls:
+  enabled: true
+  cert_file: `+certPath+`
+  key_file: `+keyPath+`
+kerberos:
+  enabled: false
+`), 0644))
+	defer os.Remove(configPath)
+
+	setEnv(configFileEnvvar, configPath)
+
+	defer func() {
+		cleanUpWDFile("listeners-config")
+		cleanUpWDFile("advertised-listeners-config")
+		cleanUpWDFile("security.inter.broker.protocol")
+		cleanUpWDFile("ssl-keystore-location-config")
+		cleanUpWDFile("ssl-keystore-key-location-config")
+		cleanUpWDFile("ssl-truststore-location-config")
+	}()
+
+	asrt.NoError(calculateSettings())
+
+	out, err := readWDFile("ssl-keystore-location-config")
+	asrt.NoError(err)
+	asrt.Equal("ssl.keystore.location="+certPath, string(out))
+
+	out, err = readWDFile("ssl-keystore-key-location-config")
+	asrt.NoError(err)
+	asrt.Equal("ssl.keystore.key.location="+keyPath, string(out))
+
+	out, err = readWDFile("ssl-truststore-location-config")
+	asrt.NoError(err)
+	asrt.Equal("ssl.truststore.location="+certPath, string(out))
+}
+
+func TestCalculateSettingsACMEMissingConfig(t *testing.T) {
+	asrt := assert.New(t)
+
+	os.Clearenv()
+	setEnv(acmeEnabledEnvvar, "true")
+	setEnv(brokerPort, "1000")
+	setEnv(brokerPortTLS, "1001")
+	setEnv(taskNameEnvvar, "a-task")
+	setEnv(frameworkHostEnvvar, "a-framework")
+	setEnv(ipEnvvar, "127.0.0.1")
+
+	err := calculateSettings()
+	asrt.Error(err)
+	asrt.Contains(err.Error(), acmeDirectoryURLEnvvar)
+}
+
+func TestWriteACMECertificate(t *testing.T) {
+	asrt := assert.New(t)
+
+	defer func() {
+		cleanUpWDFile(acmeCertFile)
+		cleanUpWDFile(acmeKeyFile)
+		cleanUpWDFile("ssl-keystore-location-config")
+		cleanUpWDFile("ssl-keystore-key-location-config")
+		cleanUpWDFile("ssl-truststore-location-config")
+	}()
+
+	cert := &acme.Certificate{
+		CertPEM:  []byte("cert-pem"),
+		KeyPEM:   []byte("key-pem"),
+		NotAfter: time.Now().Add(90 * 24 * time.Hour),
+	}
+	asrt.NoError(writeACMECertificate(cert))
+
+	wd, _ := os.Getwd()
+
+	out, err := readWDFile("ssl-keystore-location-config")
+	asrt.NoError(err)
+	asrt.Equal("ssl.keystore.location="+path.Join(wd, acmeCertFile), string(out))
+
+	out, err = readWDFile("ssl-keystore-key-location-config")
+	asrt.NoError(err)
+	asrt.Equal("ssl.keystore.key.location="+path.Join(wd, acmeKeyFile), string(out))
+
+	out, err = readWDFile("ssl-truststore-location-config")
+	asrt.NoError(err)
+	asrt.Equal("ssl.truststore.location="+path.Join(wd, acmeCertFile), string(out))
+}
+
 var listenerTests = []struct {
-	kerberosEnvvarValue         string
-	tlsEncryptionEnvvarValue    string
-	tlsAllowPlainEnvvarValue    string
-	errorExpected               bool
-	expectedListeners           string
-	expectedAdvertisedListeners string
+	kerberosEnvvarValue              string
+	tlsEncryptionEnvvarValue         string
+	tlsAllowPlainEnvvarValue         string
+	sslClientAuthEnvvarValue         string
+	errorExpected                    bool
+	expectedListeners                string
+	expectedAdvertisedListeners      string
+	expectedClientAuthConfig         string
+	expectedListenerClientAuthConfig string
 }{
 	{ // Bad boolean
 		kerberosEnvvarValue:      "nope",
@@ -118,6 +261,42 @@ var listenerTests = []struct {
 		expectedListeners:           "listeners=SSL://127.0.0.1:1001,PLAINTEXT://127.0.0.1:1000",
 		expectedAdvertisedListeners: "advertised.listeners=SSL://a-task.a-framework:1001,PLAINTEXT://a-task.a-framework:1000",
 	},
+	{ // mTLS required, no plaintext fallback
+		kerberosEnvvarValue:              "false",
+		tlsEncryptionEnvvarValue:         "true",
+		tlsAllowPlainEnvvarValue:         "false",
+		sslClientAuthEnvvarValue:         "required",
+		errorExpected:                    false,
+		expectedListeners:                "listeners=SSL://127.0.0.1:1001",
+		expectedAdvertisedListeners:      "advertised.listeners=SSL://a-task.a-framework:1001",
+		expectedClientAuthConfig:         "ssl.client.auth=required",
+		expectedListenerClientAuthConfig: "listener.name.ssl.ssl.client.auth=required",
+	},
+	{ // mTLS requested (not required), no plaintext fallback
+		kerberosEnvvarValue:              "false",
+		tlsEncryptionEnvvarValue:         "true",
+		tlsAllowPlainEnvvarValue:         "false",
+		sslClientAuthEnvvarValue:         "requested",
+		errorExpected:                    false,
+		expectedListeners:                "listeners=SSL://127.0.0.1:1001",
+		expectedAdvertisedListeners:      "advertised.listeners=SSL://a-task.a-framework:1001",
+		expectedClientAuthConfig:         "ssl.client.auth=requested",
+		expectedListenerClientAuthConfig: "listener.name.ssl.ssl.client.auth=requested",
+	},
+	{ // mTLS required cannot be combined with a plaintext fallback listener
+		kerberosEnvvarValue:      "false",
+		tlsEncryptionEnvvarValue: "true",
+		tlsAllowPlainEnvvarValue: "true",
+		sslClientAuthEnvvarValue: "required",
+		errorExpected:            true,
+	},
+	{ // Bad ssl.client.auth value
+		kerberosEnvvarValue:      "false",
+		tlsEncryptionEnvvarValue: "true",
+		tlsAllowPlainEnvvarValue: "false",
+		sslClientAuthEnvvarValue: "bogus",
+		errorExpected:            true,
+	},
 }
 
 func TestSetListeners(t *testing.T) {
@@ -127,12 +306,15 @@ func TestSetListeners(t *testing.T) {
 
 		cleanUpWDFile("listeners-config")
 		cleanUpWDFile("advertised-listeners-config")
+		cleanUpWDFile("ssl-client-auth-config")
+		cleanUpWDFile("listener-ssl-client-auth-config")
 
 		// Set the envvars
 		os.Clearenv()
 		setEnv(kerberosEnvvar, test.kerberosEnvvarValue)
 		setEnv(tlsEncryptionEnvvar, test.tlsEncryptionEnvvarValue)
 		setEnv(tlsAllowPlainEnvvar, test.tlsAllowPlainEnvvarValue)
+		setEnv(sslClientAuthEnvvar, test.sslClientAuthEnvvarValue)
 		setEnv(brokerPort, "1000")
 		setEnv(brokerPortTLS, "1001")
 		setEnv(taskNameEnvvar, "a-task")
@@ -153,11 +335,25 @@ func TestSetListeners(t *testing.T) {
 		out, err = readWDFile("advertised-listeners-config")
 		asrt.NoError(err)
 		asrt.Equal(test.expectedAdvertisedListeners, string(out))
+
+		if test.expectedClientAuthConfig == "" {
+			continue
+		}
+
+		out, err = readWDFile("ssl-client-auth-config")
+		asrt.NoError(err)
+		asrt.Equal(test.expectedClientAuthConfig, string(out))
+
+		out, err = readWDFile("listener-ssl-client-auth-config")
+		asrt.NoError(err)
+		asrt.Equal(test.expectedListenerClientAuthConfig, string(out))
 	}
 
 	// Don't leave a trace.
 	cleanUpWDFile("listeners-config")
 	cleanUpWDFile("advertised-listeners-config")
+	cleanUpWDFile("ssl-client-auth-config")
+	cleanUpWDFile("listener-ssl-client-auth-config")
 }
 func TestGetBooleanEnvvar(t *testing.T) {
 	asrt := assert.New(t)
@@ -176,9 +372,9 @@ func TestGetListener(t *testing.T) {
 	asrt := assert.New(t)
 
 	os.Setenv(ipEnvvar, "127.0.0.1")
-	os.Setenv(brokerPort, "1000")
 
-	asrt.Equal("PLAINTEXT://127.0.0.1:1000", getListener("PLAINTEXT", brokerPort))
+	d := listenerDescriptor{name: "PLAINTEXT", protocol: "PLAINTEXT", port: "1000"}
+	asrt.Equal("PLAINTEXT://127.0.0.1:1000", getListener(d))
 	os.Clearenv()
 }
 
@@ -186,9 +382,9 @@ func TestGetListenerTLS(t *testing.T) {
 	asrt := assert.New(t)
 
 	os.Setenv(ipEnvvar, "127.0.0.1")
-	os.Setenv(brokerPortTLS, "1001")
 
-	asrt.Equal("SSL://127.0.0.1:1001", getListener("SSL", brokerPortTLS))
+	d := listenerDescriptor{name: "SSL", protocol: "SSL", port: "1001"}
+	asrt.Equal("SSL://127.0.0.1:1001", getListener(d))
 	os.Clearenv()
 }
 
@@ -197,9 +393,9 @@ func TestGetAdvertisedListener(t *testing.T) {
 
 	os.Setenv(taskNameEnvvar, "a-task")
 	os.Setenv(frameworkHostEnvvar, "a-framework")
-	os.Setenv(brokerPort, "1000")
 
-	asrt.Equal("PLAINTEXT://a-task.a-framework:1000", getAdvertisedListener("PLAINTEXT", brokerPort))
+	d := listenerDescriptor{name: "PLAINTEXT", protocol: "PLAINTEXT", port: "1000"}
+	asrt.Equal("PLAINTEXT://a-task.a-framework:1000", getAdvertisedListener(d))
 	os.Clearenv()
 }
 
@@ -208,12 +404,280 @@ func TestGetAdvertisedListenerTLS(t *testing.T) {
 
 	os.Setenv(taskNameEnvvar, "a-task")
 	os.Setenv(frameworkHostEnvvar, "a-framework")
-	os.Setenv(brokerPortTLS, "1001")
 
-	asrt.Equal("SSL://a-task.a-framework:1001", getAdvertisedListener("SSL", brokerPortTLS))
+	d := listenerDescriptor{name: "SSL", protocol: "SSL", port: "1001"}
+	asrt.Equal("SSL://a-task.a-framework:1001", getAdvertisedListener(d))
 	os.Clearenv()
 }
 
+func TestGetAdvertisedListenerExplicitHost(t *testing.T) {
+	asrt := assert.New(t)
+
+	d := listenerDescriptor{name: "EXTERNAL", protocol: "SASL_SSL", port: "9094", advertisedHost: "edge.example.com"}
+	asrt.Equal("EXTERNAL://edge.example.com:9094", getAdvertisedListener(d))
+}
+
+var listenersSpecTests = []struct {
+	name                        string
+	spec                        string
+	errorExpected               bool
+	expectedListeners           string
+	expectedAdvertisedListeners string
+	expectedProtocolMap         string
+	expectedInterBrokerListener string
+}{
+	{
+		name:                        "comma-separated tuples",
+		spec:                        "INTERNAL:PLAINTEXT:9092:broker.internal,EXTERNAL:SASL_SSL:9093:kafka.example.com,REPLICATION:SSL:9094:broker.internal",
+		expectedListeners:           "listeners=INTERNAL://127.0.0.1:9092,EXTERNAL://127.0.0.1:9093,REPLICATION://127.0.0.1:9094",
+		expectedAdvertisedListeners: "advertised.listeners=INTERNAL://broker.internal:9092,EXTERNAL://kafka.example.com:9093,REPLICATION://broker.internal:9094",
+		expectedProtocolMap:         "listener.security.protocol.map=INTERNAL:PLAINTEXT,EXTERNAL:SASL_SSL,REPLICATION:SSL",
+		expectedInterBrokerListener: "inter.broker.listener.name=INTERNAL",
+	},
+	{
+		name:                        "JSON array",
+		spec:                        `[{"name":"EXTERNAL","protocol":"SASL_SSL","port":"9093","advertisedHost":"kafka.example.com"}]`,
+		expectedListeners:           "listeners=EXTERNAL://127.0.0.1:9093",
+		expectedAdvertisedListeners: "advertised.listeners=EXTERNAL://kafka.example.com:9093",
+		expectedProtocolMap:         "listener.security.protocol.map=EXTERNAL:SASL_SSL",
+		expectedInterBrokerListener: "inter.broker.listener.name=EXTERNAL",
+	},
+	{
+		name:          "malformed tuple",
+		spec:          "INTERNAL:PLAINTEXT:9092",
+		errorExpected: true,
+	},
+	{
+		name:          "non-numeric port",
+		spec:          "INTERNAL:PLAINTEXT:nope:broker.internal",
+		errorExpected: true,
+	},
+}
+
+func TestSetNamedListeners(t *testing.T) {
+	asrt := assert.New(t)
+	for _, test := range listenersSpecTests {
+		log.Print(test.name)
+
+		cleanUpWDFile("listeners-config")
+		cleanUpWDFile("advertised-listeners-config")
+		cleanUpWDFile("listener-security-protocol-map-config")
+		cleanUpWDFile("inter-broker-listener-name-config")
+
+		os.Clearenv()
+		setEnv(ipEnvvar, "127.0.0.1")
+		setEnv(listenersSpecEnvvar, test.spec)
+
+		err := setListeners()
+		if test.errorExpected {
+			asrt.Error(err, test.name)
+			continue
+		}
+		asrt.NoError(err, test.name)
+
+		out, err := readWDFile("listeners-config")
+		asrt.NoError(err)
+		asrt.Equal(test.expectedListeners, string(out), test.name)
+
+		out, err = readWDFile("advertised-listeners-config")
+		asrt.NoError(err)
+		asrt.Equal(test.expectedAdvertisedListeners, string(out), test.name)
+
+		out, err = readWDFile("listener-security-protocol-map-config")
+		asrt.NoError(err)
+		asrt.Equal(test.expectedProtocolMap, string(out), test.name)
+
+		out, err = readWDFile("inter-broker-listener-name-config")
+		asrt.NoError(err)
+		asrt.Equal(test.expectedInterBrokerListener, string(out), test.name)
+
+		// security.inter.broker.protocol must never be written alongside
+		// inter.broker.listener.name -- Kafka refuses to start with both set.
+		_, err = readWDFile("security.inter.broker.protocol")
+		asrt.Error(err, "security.inter.broker.protocol should not be written for named listeners: "+test.name)
+	}
+
+	cleanUpWDFile("listeners-config")
+	cleanUpWDFile("advertised-listeners-config")
+	cleanUpWDFile("listener-security-protocol-map-config")
+	cleanUpWDFile("inter-broker-listener-name-config")
+}
+
+var namedListenerInterBrokerSaslTests = []struct {
+	name                      string
+	spec                      string
+	saslMechanismsEnvvarValue string
+	expectedMechanisms        string
+	expectedInterBrokerMech   string
+}{
+	{
+		name:                      "plaintext inter-broker listener: no SASL config even with mechanisms set",
+		spec:                      "INTERNAL:PLAINTEXT:9092:broker.internal,EXTERNAL:SASL_SSL:9093:kafka.example.com",
+		saslMechanismsEnvvarValue: saslMechanismScram256,
+	},
+	{
+		name:                      "SASL_SSL inter-broker listener derives its own SASL config",
+		spec:                      "INTERNAL:SASL_SSL:9092:broker.internal,EXTERNAL:SSL:9093:kafka.example.com",
+		saslMechanismsEnvvarValue: saslMechanismScram256,
+		expectedMechanisms:        "sasl.enabled.mechanisms=" + saslMechanismScram256,
+		expectedInterBrokerMech:   "sasl.mechanism.inter.broker.protocol=" + saslMechanismScram256,
+	},
+	{
+		name: "SASL_SSL inter-broker listener but no mechanisms configured",
+		spec: "INTERNAL:SASL_SSL:9092:broker.internal",
+	},
+}
+
+func TestSetNamedListenersInterBrokerSasl(t *testing.T) {
+	asrt := assert.New(t)
+	saslConfigFiles := []string{
+		"security.inter.broker.protocol",
+		"sasl-enabled-mechanisms-config",
+		"sasl-mechanism-inter-broker-protocol-config",
+		"kafka_server_jaas.conf",
+	}
+	wdFiles := append([]string{
+		"listeners-config",
+		"advertised-listeners-config",
+		"listener-security-protocol-map-config",
+		"inter-broker-listener-name-config",
+		"ssl-client-auth-config",
+	}, saslConfigFiles...)
+
+	for _, test := range namedListenerInterBrokerSaslTests {
+		log.Print(test.name)
+
+		for _, f := range wdFiles {
+			cleanUpWDFile(f)
+		}
+
+		os.Clearenv()
+		setEnv(ipEnvvar, "127.0.0.1")
+		setEnv(listenersSpecEnvvar, test.spec)
+		setEnv(saslMechanismsEnvvar, test.saslMechanismsEnvvarValue)
+
+		asrt.NoError(setListeners(), test.name)
+
+		_, err := readWDFile("security.inter.broker.protocol")
+		asrt.Error(err, "security.inter.broker.protocol should never be written: "+test.name)
+
+		if test.expectedMechanisms == "" {
+			_, err = readWDFile("sasl-enabled-mechanisms-config")
+			asrt.Error(err, "no sasl-enabled-mechanisms-config expected: "+test.name)
+			continue
+		}
+
+		out, err := readWDFile("sasl-enabled-mechanisms-config")
+		asrt.NoError(err)
+		asrt.Equal(test.expectedMechanisms, string(out), test.name)
+
+		out, err = readWDFile("sasl-mechanism-inter-broker-protocol-config")
+		asrt.NoError(err)
+		asrt.Equal(test.expectedInterBrokerMech, string(out), test.name)
+
+		out, err = readWDFile("kafka_server_jaas.conf")
+		asrt.NoError(err)
+		asrt.Contains(string(out), "KafkaServer {", test.name)
+	}
+
+	for _, f := range wdFiles {
+		cleanUpWDFile(f)
+	}
+}
+
+var principalMappingRulesTests = []struct {
+	name                                string
+	sslClientAuthEnvvarValue            string
+	sslPrincipalMappingRulesEnvvarValue string
+	errorExpected                       bool
+	expectedClientAuthConfig            string
+	expectedMappingRulesConfig          string
+	expectedListenerClientAuthConfig    string
+}{
+	{ // No client auth configured: nothing written at all.
+		name: "unset",
+	},
+	{
+		name:                                "required with mapping rules",
+		sslClientAuthEnvvarValue:            "required",
+		sslPrincipalMappingRulesEnvvarValue: "RULE:^CN=(.*?),OU=ServiceUsers.*$/$1/,DEFAULT",
+		expectedClientAuthConfig:            "ssl.client.auth=required",
+		expectedMappingRulesConfig:          "ssl.principal.mapping.rules=RULE:^CN=(.*?),OU=ServiceUsers.*$/$1/,DEFAULT",
+		expectedListenerClientAuthConfig:    "listener.name.internal.ssl.client.auth=required",
+	},
+	{
+		name:                             "requested without mapping rules",
+		sslClientAuthEnvvarValue:         "requested",
+		expectedClientAuthConfig:         "ssl.client.auth=requested",
+		expectedListenerClientAuthConfig: "listener.name.internal.ssl.client.auth=requested",
+	},
+	{
+		name:                     "bad client auth value",
+		sslClientAuthEnvvarValue: "sometimes",
+		errorExpected:            true,
+	},
+}
+
+func TestSetPrincipalMappingRules(t *testing.T) {
+	asrt := assert.New(t)
+	for _, test := range principalMappingRulesTests {
+		log.Print(test.name)
+
+		cleanUpWDFile("listeners-config")
+		cleanUpWDFile("advertised-listeners-config")
+		cleanUpWDFile("listener-security-protocol-map-config")
+		cleanUpWDFile("inter-broker-listener-name-config")
+		cleanUpWDFile("ssl-client-auth-config")
+		cleanUpWDFile("ssl-principal-mapping-rules-config")
+		cleanUpWDFile("listener-ssl-client-auth-config")
+
+		os.Clearenv()
+		setEnv(ipEnvvar, "127.0.0.1")
+		setEnv(listenersSpecEnvvar, "INTERNAL:SSL:1001:broker.example.com")
+		setEnv(sslClientAuthEnvvar, test.sslClientAuthEnvvarValue)
+		setEnv(sslPrincipalMappingRulesEnvvar, test.sslPrincipalMappingRulesEnvvarValue)
+
+		err := setListeners()
+		if test.errorExpected {
+			asrt.Error(err, test.name)
+			continue
+		}
+		asrt.NoError(err, test.name)
+
+		if test.expectedClientAuthConfig == "" {
+			_, err := readWDFile("ssl-client-auth-config")
+			asrt.Error(err, test.name)
+			continue
+		}
+
+		out, err := readWDFile("ssl-client-auth-config")
+		asrt.NoError(err, test.name)
+		asrt.Equal(test.expectedClientAuthConfig, string(out), test.name)
+
+		out, err = readWDFile("listener-ssl-client-auth-config")
+		asrt.NoError(err, test.name)
+		asrt.Equal(test.expectedListenerClientAuthConfig, string(out), test.name)
+
+		if test.expectedMappingRulesConfig == "" {
+			_, err := readWDFile("ssl-principal-mapping-rules-config")
+			asrt.Error(err, test.name)
+			continue
+		}
+
+		out, err = readWDFile("ssl-principal-mapping-rules-config")
+		asrt.NoError(err, test.name)
+		asrt.Equal(test.expectedMappingRulesConfig, string(out), test.name)
+	}
+
+	cleanUpWDFile("listeners-config")
+	cleanUpWDFile("advertised-listeners-config")
+	cleanUpWDFile("listener-security-protocol-map-config")
+	cleanUpWDFile("inter-broker-listener-name-config")
+	cleanUpWDFile("ssl-client-auth-config")
+	cleanUpWDFile("ssl-principal-mapping-rules-config")
+	cleanUpWDFile("listener-ssl-client-auth-config")
+}
+
 func TestWriteToWorkingDirectory(t *testing.T) {
 	asrt := assert.New(t)
 
@@ -231,49 +695,98 @@ func TestWriteToWorkingDirectory(t *testing.T) {
 }
 
 var brokerProtocolTests = []struct {
-	kerberosEnvvarValue string
-	tlsEnvvarValue      string
-	expectedError       bool
-	expectedProtocol    string
+	kerberosEnvvarValue       string
+	saslMechanismsEnvvarValue string
+	tlsEnvvarValue            string
+	expectedError             bool
+	expectedProtocol          string
+	expectedMechanisms        string
+	expectedInterBrokerMech   string
 }{
 	{ // Bad envvar
 		kerberosEnvvarValue: "nope",
 		tlsEnvvarValue:      "true",
 		expectedError:       true,
-		expectedProtocol:    "",
 	},
 	{ // Kerberos on, tls off
-		kerberosEnvvarValue: "true",
-		tlsEnvvarValue:      "false",
-		expectedError:       false,
-		expectedProtocol:    "security.inter.broker.protocol=SASL_PLAINTEXT",
+		kerberosEnvvarValue:     "true",
+		tlsEnvvarValue:          "false",
+		expectedProtocol:        "security.inter.broker.protocol=SASL_PLAINTEXT",
+		expectedMechanisms:      "sasl.enabled.mechanisms=GSSAPI",
+		expectedInterBrokerMech: "sasl.mechanism.inter.broker.protocol=GSSAPI",
 	},
 	{ // Kerberos on, tls on
-		kerberosEnvvarValue: "true",
-		tlsEnvvarValue:      "true",
-		expectedError:       false,
-		expectedProtocol:    "security.inter.broker.protocol=SASL_SSL",
+		kerberosEnvvarValue:     "true",
+		tlsEnvvarValue:          "true",
+		expectedProtocol:        "security.inter.broker.protocol=SASL_SSL",
+		expectedMechanisms:      "sasl.enabled.mechanisms=GSSAPI",
+		expectedInterBrokerMech: "sasl.mechanism.inter.broker.protocol=GSSAPI",
 	},
 	{ // Kerberos off, tls on
 		kerberosEnvvarValue: "false",
 		tlsEnvvarValue:      "true",
-		expectedError:       false,
 		expectedProtocol:    "security.inter.broker.protocol=SSL",
 	},
+	{ // Kerberos off, tls off, nothing set
+		expectedProtocol: "security.inter.broker.protocol=PLAINTEXT",
+	},
+	{ // PLAIN mechanism, tls off
+		saslMechanismsEnvvarValue: "PLAIN",
+		tlsEnvvarValue:            "false",
+		expectedProtocol:          "security.inter.broker.protocol=SASL_PLAINTEXT",
+		expectedMechanisms:        "sasl.enabled.mechanisms=PLAIN",
+		expectedInterBrokerMech:   "sasl.mechanism.inter.broker.protocol=PLAIN",
+	},
+	{ // SCRAM-SHA-256 and SCRAM-SHA-512, tls on
+		saslMechanismsEnvvarValue: "SCRAM-SHA-256,SCRAM-SHA-512",
+		tlsEnvvarValue:            "true",
+		expectedProtocol:          "security.inter.broker.protocol=SASL_SSL",
+		expectedMechanisms:        "sasl.enabled.mechanisms=SCRAM-SHA-256,SCRAM-SHA-512",
+		expectedInterBrokerMech:   "sasl.mechanism.inter.broker.protocol=SCRAM-SHA-256",
+	},
+	{ // OAUTHBEARER, tls off
+		saslMechanismsEnvvarValue: "OAUTHBEARER",
+		tlsEnvvarValue:            "false",
+		expectedProtocol:          "security.inter.broker.protocol=SASL_PLAINTEXT",
+		expectedMechanisms:        "sasl.enabled.mechanisms=OAUTHBEARER",
+		expectedInterBrokerMech:   "sasl.mechanism.inter.broker.protocol=OAUTHBEARER",
+	},
+	{ // GSSAPI alongside SCRAM via KAFKA_SASL_MECHANISMS, tls on: GSSAPI wins inter-broker
+		saslMechanismsEnvvarValue: "SCRAM-SHA-256,GSSAPI",
+		tlsEnvvarValue:            "true",
+		expectedProtocol:          "security.inter.broker.protocol=SASL_SSL",
+		expectedMechanisms:        "sasl.enabled.mechanisms=SCRAM-SHA-256,GSSAPI",
+		expectedInterBrokerMech:   "sasl.mechanism.inter.broker.protocol=GSSAPI",
+	},
+	{ // Bad mechanism name
+		saslMechanismsEnvvarValue: "BOGUS",
+		tlsEnvvarValue:            "false",
+		expectedError:             true,
+	},
 }
 
 func TestSetInterBrokerProtocol(t *testing.T) {
 	asrt := assert.New(t)
+	saslConfigFiles := []string{
+		"security.inter.broker.protocol",
+		"sasl-enabled-mechanisms-config",
+		"sasl-mechanism-inter-broker-protocol-config",
+		"kafka_server_jaas.conf",
+	}
 	for _, test := range brokerProtocolTests {
 		// Wipe environment.
 		os.Clearenv()
-		cleanUpWDFile("security.inter.broker.protocol")
+		for _, f := range saslConfigFiles {
+			cleanUpWDFile(f)
+		}
 
 		log.Print(test)
 
 		// Set environment
 		setEnv(kerberosEnvvar, test.kerberosEnvvarValue)
+		setEnv(saslMechanismsEnvvar, test.saslMechanismsEnvvarValue)
 		setEnv(tlsEncryptionEnvvar, test.tlsEnvvarValue)
+		setEnv(kerberosPrimaryEnvvar, "a-kerberos")
 
 		err := setInterBrokerProtocol()
 		if test.expectedError {
@@ -285,10 +798,30 @@ func TestSetInterBrokerProtocol(t *testing.T) {
 		out, err := readWDFile("security.inter.broker.protocol")
 		asrt.NoError(err)
 		asrt.Equal(test.expectedProtocol, string(out))
+
+		if test.expectedMechanisms == "" {
+			_, err = readWDFile("sasl-enabled-mechanisms-config")
+			asrt.Error(err, "no sasl-enabled-mechanisms-config expected")
+			continue
+		}
+
+		out, err = readWDFile("sasl-enabled-mechanisms-config")
+		asrt.NoError(err)
+		asrt.Equal(test.expectedMechanisms, string(out))
+
+		out, err = readWDFile("sasl-mechanism-inter-broker-protocol-config")
+		asrt.NoError(err)
+		asrt.Equal(test.expectedInterBrokerMech, string(out))
+
+		out, err = readWDFile("kafka_server_jaas.conf")
+		asrt.NoError(err)
+		asrt.Contains(string(out), "KafkaServer {")
 	}
 
 	// Leave no trace.
-	cleanUpWDFile("security.inter.broker.protocol")
+	for _, f := range saslConfigFiles {
+		cleanUpWDFile(f)
+	}
 }
 
 func cleanUpWDFile(file string) {
@@ -305,4 +838,4 @@ func setEnv(envvar string, value string) {
 	if value != "" {
 		os.Setenv(envvar, value)
 	}
-}
\ No newline at end of file
+}